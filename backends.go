@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+type BackendConfig struct {
+	Name                  string `yaml:"name"`
+	Host                  string `yaml:"host"`
+	Port                  int    `yaml:"port"`
+	Socket                string `yaml:"socket"`
+	Username              string `yaml:"username"`
+	Password              string `yaml:"password"`
+	Timeout               string `yaml:"timeout"`
+	AvailableWhenDonor    bool   `yaml:"availableWhenDonor"`
+	AvailableWhenReadonly bool   `yaml:"availableWhenReadonly"`
+	RequireMaster         bool   `yaml:"requireMaster"`
+	RequirePrimary        bool   `yaml:"requirePrimary"`
+	MinClusterSize        int    `yaml:"minClusterSize"`
+}
+
+type BackendsConfig struct {
+	Backends []BackendConfig `yaml:"backends"`
+}
+
+func loadBackendsConfig(path string) (*BackendsConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg BackendsConfig
+	if err := yaml.Unmarshal(content, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (b BackendConfig) dataSourceName() string {
+	timeout := b.Timeout
+	if timeout == "" {
+		timeout = "10s"
+	}
+
+	if b.Host != "" {
+		return fmt.Sprintf("%s:%s@tcp(%s:%d)/?timeout=%s", b.Username, b.Password, b.Host, b.Port, timeout)
+	}
+	return fmt.Sprintf("%s:%s@unix(%s)/?timeout=%s", b.Username, b.Password, b.Socket, timeout)
+}
+
+type backendHub struct {
+	checkers map[string]*Checker
+}
+
+func newBackendHub(cfg *BackendsConfig) (*backendHub, error) {
+	hub := &backendHub{checkers: make(map[string]*Checker, len(cfg.Backends))}
+
+	for _, b := range cfg.Backends {
+		opts := CheckerOptions{
+			AvailableWhenDonor:    b.AvailableWhenDonor,
+			AvailableWhenReadonly: b.AvailableWhenReadonly,
+			RequireMaster:         b.RequireMaster,
+			RequirePrimary:        b.RequirePrimary,
+			MinClusterSize:        b.MinClusterSize,
+		}
+
+		checker, err := newChecker(b.dataSourceName(), opts)
+		if err != nil {
+			return nil, fmt.Errorf("backend %s: %v", b.Name, err)
+		}
+		hub.checkers[b.Name] = checker
+	}
+
+	return hub, nil
+}
+
+func (h *backendHub) register() {
+	http.HandleFunc("/backend/", func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/backend/")
+		parts := strings.SplitN(path, "/", 2)
+
+		checker, ok := h.checkers[parts[0]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		requireMaster := checker.opts.RequireMaster
+		if len(parts) > 1 && parts[1] == "master" {
+			requireMaster = true
+		}
+
+		checker.Clustercheck(w, r, requireMaster, forceUp, forceDown)
+	})
+
+	http.HandleFunc("/all", func(w http.ResponseWriter, r *http.Request) {
+		for name, checker := range h.checkers {
+			rec := httptest.NewRecorder()
+			checker.Clustercheck(rec, r, checker.opts.RequireMaster, forceUp, forceDown)
+			if rec.Code != http.StatusOK {
+				log.Println(name, "not healthy:", rec.Body.String())
+				http.Error(w, fmt.Sprintf("Backend %s: %s", name, rec.Body.String()), rec.Code)
+				return
+			}
+		}
+		fmt.Fprint(w, "All backends synced\n")
+	})
+}
+
+func (h *backendHub) reload(cfg *BackendsConfig) {
+	for _, b := range cfg.Backends {
+		checker, ok := h.checkers[b.Name]
+		if !ok {
+			continue
+		}
+		if err := checker.reload(b.dataSourceName()); err != nil {
+			log.Println(b.Name, "error reloading:", err)
+		}
+	}
+}
+
+func (h *backendHub) Close() {
+	for name, checker := range h.checkers {
+		if err := checker.Close(); err != nil {
+			log.Println(name, "error closing database:", err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import "time"
+
+type statusTuple struct {
+	readOnly           string
+	wsrepLocalState    int
+	wsrepLocalIndex    int
+	wsrepClusterStatus string
+	wsrepClusterSize   int
+}
+
+func (c *Checker) status() (statusTuple, error) {
+	if c.cacheTTL <= 0 {
+		return c.queryStatus()
+	}
+
+	c.cacheMu.Lock()
+	if !c.cachedAt.IsZero() && time.Since(c.cachedAt) < c.cacheTTL {
+		cached := c.cached
+		c.cacheMu.Unlock()
+		return cached, nil
+	}
+	c.cacheMu.Unlock()
+
+	v, err, _ := c.cacheGroup.Do("status", func() (interface{}, error) {
+		return c.queryStatus()
+	})
+	if err != nil {
+		return statusTuple{}, err
+	}
+
+	status := v.(statusTuple)
+	c.cacheMu.Lock()
+	c.cached = status
+	c.cachedAt = time.Now()
+	c.cacheMu.Unlock()
+	return status, nil
+}
+
+func (c *Checker) queryStatus() (statusTuple, error) {
+	var fieldName string
+	var status statusTuple
+	stmts := c.stmts()
+
+	if err := stmts.readOnly.QueryRow().Scan(&fieldName, &status.readOnly); err != nil {
+		return statusTuple{}, err
+	}
+	if err := stmts.wsrepLocalState.QueryRow().Scan(&fieldName, &status.wsrepLocalState); err != nil {
+		return statusTuple{}, err
+	}
+	if err := stmts.wsrepLocalIndex.QueryRow().Scan(&fieldName, &status.wsrepLocalIndex); err != nil {
+		return statusTuple{}, err
+	}
+	if err := stmts.wsrepClusterStatus.QueryRow().Scan(&fieldName, &status.wsrepClusterStatus); err != nil {
+		return statusTuple{}, err
+	}
+	if err := stmts.wsrepClusterSize.QueryRow().Scan(&fieldName, &status.wsrepClusterSize); err != nil {
+		return statusTuple{}, err
+	}
+	return status, nil
+}
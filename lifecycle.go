@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/coreos/go-systemd/daemon"
+)
+
+func watchWatchdog() {
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil || interval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		_, _ = daemon.SdNotify(false, "WATCHDOG=1")
+	}
+}
+
+func watchReloadSignal(checker *Checker) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+
+	for range sigCh {
+		log.Println("Received SIGHUP, reloading credentials")
+		if usingIniFile {
+			parseConfigFile()
+		}
+		if err := checker.reload(dataSourceName()); err != nil {
+			log.Println("error reloading credentials:", err)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"flag"
 	"fmt"
@@ -8,10 +9,16 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/coreos/go-systemd/daemon"
 	_ "github.com/go-sql-driver/mysql"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
@@ -32,59 +39,157 @@ var (
 	availableWhenDonor    = flag.Bool("donor", false, "Cluster available while node is a donor")
 	availableWhenReadonly = flag.Bool("readonly", false, "Cluster available while node is read only")
 	requireMaster         = flag.Bool("requiremaster", false, "Cluster available only while node is master")
+	requirePrimary        = flag.Bool("require-primary", true, "Require the node to be part of a Primary component")
+	minClusterSize        = flag.Int("min-cluster-size", 0, "Minimum wsrep_cluster_size required for the node to be available (0 disables the check)")
 	bindAddr              = flag.String("bindaddr", "", "Clustercheck bind address")
 	bindPort              = flag.Int("bindport", 8000, "Clustercheck bind port")
 	debug                 = flag.Bool("debug", false, "Debug mode. Will also print successfull 200 HTTP responses to stdout")
+	metricsEnabled        = flag.Bool("metrics", false, "Enable a Prometheus /metrics endpoint")
+	metricsBindAddr       = flag.String("metrics-bindaddr", "", "Metrics bind address, if different from -bindaddr")
+	metricsBindPort       = flag.Int("metrics-bindport", 0, "Metrics bind port. If 0, /metrics is served on -bindaddr:-bindport instead")
+	cacheTTL              = flag.String("cache-ttl", "500ms", "TTL for cached clustercheck status. 0 disables caching")
+	tlsCert               = flag.String("tls-cert", "", "TLS certificate file. Enables an additional HTTPS listener")
+	tlsKey                = flag.String("tls-key", "", "TLS key file. Enables an additional HTTPS listener")
+	tlsClientCA           = flag.String("tls-client-ca", "", "CA bundle to verify client certificates against. Enables mTLS on the HTTPS listener")
+	tlsBindAddr           = flag.String("tls-bindaddr", "", "HTTPS bind address, if different from -bindaddr")
+	tlsBindPort           = flag.Int("tls-bindport", 8443, "HTTPS bind port")
+	configFile            = flag.String("config", "", "Path to a YAML config file describing multiple backends. When set, clustercheck runs in multi-backend mode, -host/-port/-socket/-username/-password/-inifile/-timeout/-donor/-readonly/-requiremaster/-require-primary/-min-cluster-size are ignored, and neither the -metrics endpoint nor the HTTPS listener is started")
 	forceUp               = false
 	forceDown             = false
-	dataSourceName        = ""
+	usingIniFile          = false
 )
 
 func main() {
 	flag.Parse()
 
+	if *configFile != "" {
+		runMultiBackend()
+		return
+	}
+
 	if *username == "" && *password == "" {
 		parseConfigFile()
+		usingIniFile = true
 	}
 
-	if *host != "" {
-		dataSourceName = fmt.Sprintf("%s:%s@tcp(%s:%d)/?timeout=%s", *username, *password, *host, *port, *timeout)
-	} else {
-		dataSourceName = fmt.Sprintf("%s:%s@unix(%s)/?timeout=%s", *username, *password, *socket, *timeout)
+	opts := CheckerOptions{
+		AvailableWhenDonor:    *availableWhenDonor,
+		AvailableWhenReadonly: *availableWhenReadonly,
+		RequireMaster:         *requireMaster,
+		RequirePrimary:        *requirePrimary,
+		MinClusterSize:        *minClusterSize,
 	}
 
-	db, err := sql.Open("mysql", dataSourceName)
+	checker, err := newChecker(dataSourceName(), opts)
 	if err != nil {
-		panic(err.Error())
+		log.Fatal(err)
 	}
 
-	db.SetMaxIdleConns(10)
-	db.SetMaxOpenConns(10)
+	log.Println("Listening...")
+	http.Handle("/", instrumentCheck("root", checker.Root))
+	http.Handle("/master", instrumentCheck("master", checker.Master))
+	http.Handle("/up", instrumentCheck("up", checker.Up))
+	http.Handle("/down", instrumentCheck("down", checker.Down))
+	registerMetrics(checker)
 
-	readOnlyStmt, err := db.Prepare("SHOW GLOBAL VARIABLES LIKE 'read_only'")
-	if err != nil {
-		log.Fatal(err)
+	if *tlsCert != "" && *tlsKey != "" {
+		tlsAddr := *tlsBindAddr
+		if tlsAddr == "" {
+			tlsAddr = *bindAddr
+		}
+		go serveTLS(fmt.Sprintf("%s:%d", tlsAddr, *tlsBindPort))
 	}
 
-	wsrepLocalStateStmt, err := db.Prepare("SHOW GLOBAL STATUS LIKE 'wsrep_local_state'")
+	server := &http.Server{Addr: fmt.Sprintf("%s:%d", *bindAddr, *bindPort)}
+
+	go watchWatchdog()
+	go watchReloadSignal(checker)
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	_, _ = daemon.SdNotify(false, daemon.SdNotifyReady)
+
+	stopCh := make(chan os.Signal, 1)
+	signal.Notify(stopCh, syscall.SIGTERM, syscall.SIGINT)
+	<-stopCh
+
+	log.Println("Shutting down...")
+	_, _ = daemon.SdNotify(false, daemon.SdNotifyStopping)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Println("error during shutdown:", err)
+	}
+
+	if err := checker.Close(); err != nil {
+		log.Println("error closing database:", err)
+	}
+}
+
+func runMultiBackend() {
+	cfg, err := loadBackendsConfig(*configFile)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	wsrepLocalIndexStmt, err := db.Prepare("SHOW GLOBAL STATUS LIKE 'wsrep_local_index'")
+	hub, err := newBackendHub(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	checker := &Checker{wsrepLocalIndexStmt, wsrepLocalStateStmt, readOnlyStmt}
-
 	log.Println("Listening...")
-	http.HandleFunc("/", checker.Root)
-	http.HandleFunc("/master", checker.Master)
-	http.HandleFunc("/up", checker.Up)
-	http.HandleFunc("/down", checker.Down)
+	hub.register()
+
+	server := &http.Server{Addr: fmt.Sprintf("%s:%d", *bindAddr, *bindPort)}
+
+	reloadCh := make(chan os.Signal, 1)
+	signal.Notify(reloadCh, syscall.SIGHUP)
+	go func() {
+		for range reloadCh {
+			log.Println("Received SIGHUP, reloading backend config")
+			cfg, err := loadBackendsConfig(*configFile)
+			if err != nil {
+				log.Println("error reloading backend config:", err)
+				continue
+			}
+			hub.reload(cfg)
+		}
+	}()
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
 	_, _ = daemon.SdNotify(false, daemon.SdNotifyReady)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf("%s:%d", *bindAddr, *bindPort), nil))
+
+	stopCh := make(chan os.Signal, 1)
+	signal.Notify(stopCh, syscall.SIGTERM, syscall.SIGINT)
+	<-stopCh
+
+	log.Println("Shutting down...")
+	_, _ = daemon.SdNotify(false, daemon.SdNotifyStopping)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Println("error during shutdown:", err)
+	}
+
+	hub.Close()
+}
+
+func dataSourceName() string {
+	if *host != "" {
+		return fmt.Sprintf("%s:%s@tcp(%s:%d)/?timeout=%s", *username, *password, *host, *port, *timeout)
+	}
+	return fmt.Sprintf("%s:%s@unix(%s)/?timeout=%s", *username, *password, *socket, *timeout)
 }
 
 func parseConfigFile() {
@@ -107,13 +212,133 @@ func parseConfigFile() {
 }
 
 type Checker struct {
-	wsrepLocalIndexStmt *sql.Stmt
-	wsrepLocalStateStmt *sql.Stmt
-	readOnlyStmt        *sql.Stmt
+	db                     *sql.DB
+	wsrepLocalIndexStmt    *sql.Stmt
+	wsrepLocalStateStmt    *sql.Stmt
+	readOnlyStmt           *sql.Stmt
+	wsrepClusterStatusStmt *sql.Stmt
+	wsrepClusterSizeStmt   *sql.Stmt
+	stmtMu                 sync.RWMutex
+
+	cacheTTL   time.Duration
+	cacheMu    sync.Mutex
+	cachedAt   time.Time
+	cached     statusTuple
+	cacheGroup singleflight.Group
+
+	opts CheckerOptions
+}
+
+// CheckerOptions holds the per-backend behavior flags. In single-backend mode
+// these come from the global CLI flags; in multi-backend mode each backend
+// in the config file gets its own.
+type CheckerOptions struct {
+	AvailableWhenDonor    bool
+	AvailableWhenReadonly bool
+	RequireMaster         bool
+	RequirePrimary        bool
+	MinClusterSize        int
+}
+
+func newChecker(dsn string, opts CheckerOptions) (*Checker, error) {
+	cacheTTLDuration, err := time.ParseDuration(*cacheTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	checker := &Checker{cacheTTL: cacheTTLDuration, opts: opts}
+	if err := checker.reload(dsn); err != nil {
+		return nil, err
+	}
+	return checker, nil
+}
+
+// reload opens a fresh *sql.DB and prepared statements against dsn and swaps
+// them into the Checker, closing whatever connection was previously in use.
+// It's used both for the initial connection and for a SIGHUP credential reload.
+func (c *Checker) reload(dsn string) error {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return err
+	}
+
+	db.SetMaxIdleConns(10)
+	db.SetMaxOpenConns(10)
+
+	readOnlyStmt, err := db.Prepare("SHOW GLOBAL VARIABLES LIKE 'read_only'")
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	wsrepLocalStateStmt, err := db.Prepare("SHOW GLOBAL STATUS LIKE 'wsrep_local_state'")
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	wsrepLocalIndexStmt, err := db.Prepare("SHOW GLOBAL STATUS LIKE 'wsrep_local_index'")
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	wsrepClusterStatusStmt, err := db.Prepare("SHOW GLOBAL STATUS LIKE 'wsrep_cluster_status'")
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	wsrepClusterSizeStmt, err := db.Prepare("SHOW GLOBAL STATUS LIKE 'wsrep_cluster_size'")
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	c.stmtMu.Lock()
+	oldDB := c.db
+	c.db = db
+	c.readOnlyStmt = readOnlyStmt
+	c.wsrepLocalStateStmt = wsrepLocalStateStmt
+	c.wsrepLocalIndexStmt = wsrepLocalIndexStmt
+	c.wsrepClusterStatusStmt = wsrepClusterStatusStmt
+	c.wsrepClusterSizeStmt = wsrepClusterSizeStmt
+	c.stmtMu.Unlock()
+
+	if oldDB != nil {
+		oldDB.Close()
+	}
+	return nil
+}
+
+func (c *Checker) Close() error {
+	c.stmtMu.RLock()
+	defer c.stmtMu.RUnlock()
+	return c.db.Close()
+}
+
+type checkerStmts struct {
+	readOnly           *sql.Stmt
+	wsrepLocalState    *sql.Stmt
+	wsrepLocalIndex    *sql.Stmt
+	wsrepClusterStatus *sql.Stmt
+	wsrepClusterSize   *sql.Stmt
+}
+
+func (c *Checker) stmts() checkerStmts {
+	c.stmtMu.RLock()
+	defer c.stmtMu.RUnlock()
+	return checkerStmts{
+		readOnly:           c.readOnlyStmt,
+		wsrepLocalState:    c.wsrepLocalStateStmt,
+		wsrepLocalIndex:    c.wsrepLocalIndexStmt,
+		wsrepClusterStatus: c.wsrepClusterStatusStmt,
+		wsrepClusterSize:   c.wsrepClusterSizeStmt,
+	}
 }
 
 func (c *Checker) Root(w http.ResponseWriter, r *http.Request) {
-	c.Clustercheck(w, r, *requireMaster, forceUp, forceDown)
+	c.Clustercheck(w, r, c.opts.RequireMaster, forceUp, forceDown)
 }
 
 func (c *Checker) Master(w http.ResponseWriter, r *http.Request) {
@@ -121,25 +346,28 @@ func (c *Checker) Master(w http.ResponseWriter, r *http.Request) {
 }
 
 func (c *Checker) Up(w http.ResponseWriter, r *http.Request) {
-	c.Clustercheck(w, r, *requireMaster, true, forceDown)
+	c.Clustercheck(w, r, c.opts.RequireMaster, true, forceDown)
 }
 
 func (c *Checker) Down(w http.ResponseWriter, r *http.Request) {
-	c.Clustercheck(w, r, *requireMaster, forceUp, true)
+	c.Clustercheck(w, r, c.opts.RequireMaster, forceUp, true)
 }
 
 func (c *Checker) Clustercheck(w http.ResponseWriter, r *http.Request, requireMaster, forceUp, forceDown bool) {
-	var fieldName, readOnly string
-	var wsrepLocalState int
-	var wsrepLocalIndex int
-
 	remoteIp, _, _ := net.SplitHostPort(r.RemoteAddr)
 
+	status, statusErr := c.status()
+	if statusErr != nil {
+		log.Println(remoteIp, statusErr.Error())
+		http.Error(w, "Error while checking cluster status", http.StatusInternalServerError)
+		return
+	}
+
 	if forceUp {
 		if *debug {
 			log.Println(remoteIp, "Node available by forceUp")
 		}
-		fmt.Fprint(w, "Node available by forceUp\n")
+		respond(c, w, r, http.StatusOK, "forced_up", "Node available by forceUp\n", status)
 		return
 	}
 
@@ -147,89 +375,82 @@ func (c *Checker) Clustercheck(w http.ResponseWriter, r *http.Request, requireMa
 		if *debug {
 			log.Println(remoteIp, "Node unavailable by forceDown")
 		}
-		http.Error(w, "Node unavailable by forceDown", http.StatusServiceUnavailable)
+		respond(c, w, r, http.StatusServiceUnavailable, "forced_down", "Node unavailable by forceDown", status)
 		return
 	}
 
-	readOnlyErr := c.readOnlyStmt.QueryRow().Scan(&fieldName, &readOnly)
-	if readOnlyErr != nil {
-		log.Println(remoteIp, readOnlyErr.Error())
-		http.Error(w, "Error while running readOnlyStmt", http.StatusInternalServerError)
+	if c.opts.RequirePrimary && status.wsrepClusterStatus != "Primary" {
+		log.Println(remoteIp, "Node is not part of a Primary component")
+		respond(c, w, r, http.StatusServiceUnavailable, "not_primary", "Node is not part of a Primary component", status)
 		return
 	}
 
-	if readOnly == "ON" && !*availableWhenReadonly {
-		log.Println(remoteIp, "Node is read_only")
-		http.Error(w, "Node is read_only", http.StatusServiceUnavailable)
+	if c.opts.MinClusterSize > 0 && status.wsrepClusterSize < c.opts.MinClusterSize {
+		message := fmt.Sprintf("Cluster size %d is below minimum %d", status.wsrepClusterSize, c.opts.MinClusterSize)
+		log.Println(remoteIp, message)
+		respond(c, w, r, http.StatusServiceUnavailable, "cluster_too_small", message, status)
 		return
 	}
 
-	wsrepLocalStateErr := c.wsrepLocalStateStmt.QueryRow().Scan(&fieldName, &wsrepLocalState)
-	if wsrepLocalStateErr != nil {
-		log.Println(remoteIp, wsrepLocalStateErr.Error())
-		http.Error(w, "Error while running wsrepLocalStateStmt", http.StatusInternalServerError)
+	if status.readOnly == "ON" && !c.opts.AvailableWhenReadonly {
+		log.Println(remoteIp, "Node is read_only")
+		respond(c, w, r, http.StatusServiceUnavailable, "read_only", "Node is read_only", status)
 		return
 	}
 
-	switch wsrepLocalState {
+	switch status.wsrepLocalState {
 	case STATE_JOINING:
 		if *debug {
 			log.Println(remoteIp, "Node in Joining state")
 		}
-		http.Error(w, "Node in Joining state", http.StatusServiceUnavailable)
+		respond(c, w, r, http.StatusServiceUnavailable, "joining", "Node in Joining state", status)
 		return
 	case STATE_DONOR:
-		if *availableWhenDonor {
+		if c.opts.AvailableWhenDonor {
 			if *debug {
 				log.Println(remoteIp, "Node in Donor state")
 			}
-			fmt.Fprint(w, "Node in Donor state\n")
+			respond(c, w, r, http.StatusOK, "donor", "Node in Donor state\n", status)
 			return
 		} else {
 			if *debug {
 				log.Println(remoteIp, "Node in Donor state")
 			}
-			http.Error(w, "Node in Donor state", http.StatusServiceUnavailable)
+			respond(c, w, r, http.StatusServiceUnavailable, "donor", "Node in Donor state", status)
 			return
 		}
 	case STATE_JOINED:
 		if *debug {
 			log.Println(remoteIp, "Node in Joined state")
 		}
-		http.Error(w, "Node in Joined state", http.StatusServiceUnavailable)
+		respond(c, w, r, http.StatusServiceUnavailable, "joined", "Node in Joined state", status)
 		return
 	case STATE_SYNCED:
 		if requireMaster {
-			wsrepLocalIndexErr := c.wsrepLocalIndexStmt.QueryRow().Scan(&fieldName, &wsrepLocalIndex)
-			if wsrepLocalIndexErr != nil {
-				log.Println(remoteIp, wsrepLocalIndexErr.Error())
-				http.Error(w, "Error while running wsrepLocalIndexStmt", http.StatusInternalServerError)
-				return
-			}
-			if wsrepLocalIndex == 0 {
+			if status.wsrepLocalIndex == 0 {
 				if *debug {
 					log.Println(remoteIp, "Node in Synced state and 'wsrep_local_index==0'")
 				}
-				fmt.Fprintf(w, "Node in Synced state and 'wsrep_local_index==0'\n")
+				respond(c, w, r, http.StatusOK, "synced", "Node in Synced state and 'wsrep_local_index==0'\n", status)
 				return
-			} else if wsrepLocalIndex != 0 {
+			} else if status.wsrepLocalIndex != 0 {
 				if *debug {
 					log.Println(remoteIp, "Node in Synced state but not 'wsrep_local_index==0'")
 				}
-				http.Error(w, "Node in Synced state but not 'wsrep_local_index==0'", http.StatusServiceUnavailable)
+				respond(c, w, r, http.StatusServiceUnavailable, "synced", "Node in Synced state but not 'wsrep_local_index==0'", status)
 				return
 			}
 		}
 		if *debug {
 			log.Println(remoteIp, "Node in Synced state")
 		}
-		fmt.Fprint(w, "Node in Synced state\n")
+		respond(c, w, r, http.StatusOK, "synced", "Node in Synced state\n", status)
 		return
 	default:
 		if *debug {
-			log.Println(remoteIp, fmt.Sprintf("Node in an unknown state (%d)", wsrepLocalState))
+			log.Println(remoteIp, fmt.Sprintf("Node in an unknown state (%d)", status.wsrepLocalState))
 		}
-		http.Error(w, fmt.Sprintf("Node in an unknown state (%d)", wsrepLocalState), http.StatusServiceUnavailable)
+		respond(c, w, r, http.StatusServiceUnavailable, "unknown", fmt.Sprintf("Node in an unknown state (%d)", status.wsrepLocalState), status)
 		return
 	}
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpResponsesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "galera_clustercheck_http_responses_total",
+		Help: "Number of clustercheck HTTP responses, by route and status code.",
+	}, []string{"handler", "code"})
+
+	checkDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "galera_clustercheck_check_duration_seconds",
+		Help:    "Time spent running a clustercheck, per route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"handler"})
+
+	wsrepLocalStateDesc = prometheus.NewDesc(
+		"galera_wsrep_local_state", "Current value of wsrep_local_state (1=Joining 2=Donor 3=Joined 4=Synced).", nil, nil)
+	wsrepLocalIndexDesc = prometheus.NewDesc(
+		"galera_wsrep_local_index", "Current value of wsrep_local_index.", nil, nil)
+	readOnlyDesc = prometheus.NewDesc(
+		"galera_read_only", "1 if the node has read_only set, 0 otherwise.", nil, nil)
+)
+
+func instrumentCheck(handlerName string, handlerFunc http.HandlerFunc) http.Handler {
+	if !*metricsEnabled {
+		return handlerFunc
+	}
+
+	return promhttp.InstrumentHandlerDuration(
+		checkDurationSeconds.MustCurryWith(prometheus.Labels{"handler": handlerName}),
+		promhttp.InstrumentHandlerCounter(
+			httpResponsesTotal.MustCurryWith(prometheus.Labels{"handler": handlerName}),
+			handlerFunc,
+		),
+	)
+}
+
+type clusterGaugeCollector struct {
+	checker *Checker
+}
+
+func (c *clusterGaugeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- wsrepLocalStateDesc
+	ch <- wsrepLocalIndexDesc
+	ch <- readOnlyDesc
+}
+
+func (c *clusterGaugeCollector) Collect(ch chan<- prometheus.Metric) {
+	var fieldName, readOnly string
+	var wsrepLocalState, wsrepLocalIndex int
+	stmts := c.checker.stmts()
+
+	if err := stmts.readOnly.QueryRow().Scan(&fieldName, &readOnly); err != nil {
+		log.Println("metrics:", err.Error())
+	} else {
+		value := 0.0
+		if readOnly == "ON" {
+			value = 1.0
+		}
+		ch <- prometheus.MustNewConstMetric(readOnlyDesc, prometheus.GaugeValue, value)
+	}
+
+	if err := stmts.wsrepLocalState.QueryRow().Scan(&fieldName, &wsrepLocalState); err != nil {
+		log.Println("metrics:", err.Error())
+	} else {
+		ch <- prometheus.MustNewConstMetric(wsrepLocalStateDesc, prometheus.GaugeValue, float64(wsrepLocalState))
+	}
+
+	if err := stmts.wsrepLocalIndex.QueryRow().Scan(&fieldName, &wsrepLocalIndex); err != nil {
+		log.Println("metrics:", err.Error())
+	} else {
+		ch <- prometheus.MustNewConstMetric(wsrepLocalIndexDesc, prometheus.GaugeValue, float64(wsrepLocalIndex))
+	}
+}
+
+func registerMetrics(checker *Checker) {
+	if !*metricsEnabled {
+		return
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(httpResponsesTotal, checkDurationSeconds, &clusterGaugeCollector{checker: checker})
+	handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+
+	if *metricsBindPort == 0 {
+		http.Handle("/metrics", handler)
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	metricsAddr := *metricsBindAddr
+	if metricsAddr == "" {
+		metricsAddr = *bindAddr
+	}
+	addr := fmt.Sprintf("%s:%d", metricsAddr, *metricsBindPort)
+	go func() {
+		log.Println("Listening for metrics on", addr)
+		log.Fatal(http.ListenAndServe(addr, mux))
+	}()
+}
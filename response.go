@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type statusResponse struct {
+	Status          string `json:"status"`
+	WsrepLocalState int    `json:"wsrep_local_state"`
+	WsrepLocalIndex int    `json:"wsrep_local_index"`
+	ReadOnly        bool   `json:"read_only"`
+	DonorOK         bool   `json:"donor_ok"`
+}
+
+func respond(c *Checker, w http.ResponseWriter, r *http.Request, httpStatus int, statusLabel, message string, status statusTuple) {
+	w.Header().Set("X-Wsrep-Local-State", strconv.Itoa(status.wsrepLocalState))
+	w.Header().Set("X-Wsrep-Local-Index", strconv.Itoa(status.wsrepLocalIndex))
+	w.Header().Set("X-Read-Only", strconv.FormatBool(status.readOnly == "ON"))
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(httpStatus)
+		json.NewEncoder(w).Encode(statusResponse{
+			Status:          statusLabel,
+			WsrepLocalState: status.wsrepLocalState,
+			WsrepLocalIndex: status.wsrepLocalIndex,
+			ReadOnly:        status.readOnly == "ON",
+			DonorOK:         c.opts.AvailableWhenDonor,
+		})
+		return
+	}
+
+	if httpStatus == http.StatusOK {
+		fmt.Fprint(w, message)
+	} else {
+		http.Error(w, message, httpStatus)
+	}
+}
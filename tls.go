@@ -0,0 +1,36 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+func serveTLS(addr string) {
+	tlsConfig := &tls.Config{}
+
+	if *tlsClientCA != "" {
+		caCert, err := ioutil.ReadFile(*tlsClientCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		caPool := x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(caCert) {
+			log.Fatalf("failed to parse client CA bundle %s", *tlsClientCA)
+		}
+
+		tlsConfig.ClientCAs = caPool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	server := &http.Server{
+		Addr:      addr,
+		TLSConfig: tlsConfig,
+	}
+
+	log.Println("Listening for HTTPS on", addr)
+	log.Fatal(server.ListenAndServeTLS(*tlsCert, *tlsKey))
+}